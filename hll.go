@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cespare/xxhash/v2"
+	badger "github.com/dgraph-io/badger/v3"
+)
+
+// hllPrecision is the number of bits used to index registers. p=14 gives
+// 16384 registers (16KB at one byte each) and a standard error of ~0.8%.
+const hllPrecision = 14
+
+const (
+	hllRegisters = 1 << hllPrecision
+	hllAlpha     = 0.7213 / (1 + 1.079/float64(hllRegisters))
+)
+
+// hllSketch is a fixed-precision HyperLogLog sketch for estimating
+// cardinality in constant memory.
+type hllSketch struct {
+	registers [hllRegisters]uint8
+}
+
+func newHLLSketch() *hllSketch {
+	return &hllSketch{}
+}
+
+// add folds a 64-bit hash into the sketch: the top hllPrecision bits pick
+// the register, and the position of the first 1-bit among the remaining
+// bits (1-indexed) is the candidate register value.
+func (s *hllSketch) add(hash uint64) {
+	idx := hash >> (64 - hllPrecision)
+	rest := hash << hllPrecision
+	r := uint8(bits.LeadingZeros64(rest)) + 1
+	if max := uint8(64 - hllPrecision + 1); r > max {
+		r = max
+	}
+	if r > s.registers[idx] {
+		s.registers[idx] = r
+	}
+}
+
+// merge folds other into s register-wise (max).
+func (s *hllSketch) merge(other *hllSketch) {
+	for i, v := range other.registers {
+		if v > s.registers[i] {
+			s.registers[i] = v
+		}
+	}
+}
+
+// estimate returns the estimated cardinality of the sketch, applying the
+// linear-counting correction for small cardinalities.
+func (s *hllSketch) estimate() float64 {
+	sum := 0.0
+	zeros := 0
+	for _, v := range s.registers {
+		sum += 1.0 / float64(uint64(1)<<v)
+		if v == 0 {
+			zeros++
+		}
+	}
+
+	m := float64(hllRegisters)
+	e := hllAlpha * m * m / sum
+
+	if e <= 2.5*m && zeros > 0 {
+		return m * math.Log(m/float64(zeros))
+	}
+	return e
+}
+
+// runHLL counts unique IPs with a HyperLogLog sketch instead of persisting
+// every hash to BadgerDB. With exactVerify set, it additionally writes to
+// BadgerDB so the estimate can be checked against the true count.
+func runHLL(cfg sourceConfig, exactVerify bool, filter *ipFilter, httpStats string) {
+	var db *badger.DB
+	var batchChan chan [][]byte
+	var dbWg sync.WaitGroup
+
+	if exactVerify {
+		opts := badger.DefaultOptions(dbPath).WithLogger(nil)
+		var err error
+		db, err = badger.Open(opts)
+		if err != nil {
+			fmt.Printf("Error opening database: %v\n", err)
+			return
+		}
+		defer db.Close()
+
+		batchChan = make(chan [][]byte, 100)
+		dbWg.Add(1)
+		go databaseWriter(batchChan, db, &dbWg)
+	}
+
+	linesChan := make(chan string, 10_000)
+	global := newHLLSketch()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	go memoryMonitor()
+
+	numWorkers := runtime.NumCPU()
+	fmt.Printf("\nUsing %d workers...\n", numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go hllWorker(linesChan, batchChan, global, filter, &mu, &wg)
+	}
+
+	setUniqueCounter(func() int64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return int64(global.estimate())
+	})
+
+	if httpStats != "" {
+		go serveStats(httpStats)
+	}
+
+	longRunning := startSources(cfg, linesChan)
+
+	wg.Wait()
+	if exactVerify {
+		close(batchChan)
+		dbWg.Wait()
+	}
+	logWriter.Flush()
+
+	if longRunning {
+		return
+	}
+
+	estimate := global.estimate()
+	fmt.Printf("\nTotal lines processed: %d\n", atomic.LoadInt64(&totalLineCount))
+	fmt.Printf("Estimated unique valid IP addresses (HLL): %.0f\n", estimate)
+	fmt.Printf("Number of invalid IP addresses: %d\n", invalidIPCount)
+
+	if exactVerify {
+		exactCount := badgerCount(db)
+		fmt.Printf("Exact unique valid IP addresses (BadgerDB): %d\n", exactCount)
+		fmt.Printf("Estimate error: %.2f%%\n", (estimate-float64(exactCount))/float64(exactCount)*100)
+	}
+}
+
+// hllWorker parses lines into IPs, folding each into a worker-local HLL
+// sketch to avoid contention. The local sketch is merged into the global one
+// every batchSize counted IPs and again on exit; merging is a register-wise max,
+// so repeated merges of the same local sketch are harmless. The periodic
+// merge keeps /stats' unique_count live for long-running --listen-tcp/
+// --listen-udp feeds, where workers never exit on their own. When batchChan
+// is non-nil, it also forwards canonical IP keys so exactVerify can persist
+// them to BadgerDB.
+func hllWorker(linesChan <-chan string, batchChan chan<- [][]byte, global *hllSketch, filter *ipFilter, mu *sync.Mutex, wg *sync.WaitGroup) {
+	defer wg.Done()
+	local := newHLLSketch()
+	var keys [][]byte
+	sinceMerge := 0
+	for line := range linesChan {
+		ip := net.ParseIP(line)
+		if ip == nil {
+			atomic.AddInt32(&invalidIPCount, 1)
+			if atomic.LoadInt32(&invalidLogCount) < maxInvalidLogs {
+				logWriter.WriteString(fmt.Sprintf("Warning: invalid IP address: %s\n", line))
+				atomic.AddInt32(&invalidLogCount, 1)
+			}
+			continue
+		}
+		if filter != nil {
+			var ok bool
+			ip, ok = filter.apply(ip)
+			if !ok {
+				continue
+			}
+		}
+
+		local.add(xxhash.Sum64(ip.To16()))
+		sinceMerge++
+		if sinceMerge >= batchSize {
+			mu.Lock()
+			global.merge(local)
+			mu.Unlock()
+			sinceMerge = 0
+		}
+
+		if batchChan != nil {
+			keys = append(keys, ipKey(ip))
+			if len(keys) >= batchSize {
+				batchChan <- keys
+				keys = nil
+			}
+		}
+	}
+	if batchChan != nil && len(keys) > 0 {
+		batchChan <- keys
+	}
+
+	mu.Lock()
+	global.merge(local)
+	mu.Unlock()
+}
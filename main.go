@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"io"
 	"net"
@@ -11,7 +12,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/cespare/xxhash/v2"
 	badger "github.com/dgraph-io/badger/v3"
 )
 
@@ -38,17 +38,41 @@ func main() {
 		fmt.Printf("\nExecution time: %v\n", time.Since(start))
 	}()
 
+	mode := flag.String("mode", "exact", "counting mode: exact (BadgerDB), hll (HyperLogLog estimate), or roaring (in-memory IPv4 bitmap)")
+	exactVerify := flag.Bool("exact-verify", false, "in hll mode, also write to BadgerDB to compare estimated vs true counts")
+	httpStats := flag.String("http-stats", "", "address to serve running stats as JSON, e.g. :8080")
+	listenTCP := flag.String("listen-tcp", "", "address to accept line-delimited IPs over TCP, e.g. :9000")
+	listenUDP := flag.String("listen-udp", "", "address to accept one IP per UDP datagram, e.g. :9000")
+	stdin := flag.Bool("stdin", false, "read IPs from standard input")
+	numShards := flag.Int("shards", 4, "number of BadgerDB writer shards (exact mode)")
+	numWriters := flag.Int("writers", 4, "number of BadgerDB writer goroutines (exact mode)")
+	aggregateBy := flag.String("aggregate-by", "", "count unique subnets instead of addresses, e.g. /24")
+	var includeCIDRs, excludeCIDRs multiFlag
+	flag.Var(&includeCIDRs, "include-cidr", "only count IPs within this CIDR; may be repeated")
+	flag.Var(&excludeCIDRs, "exclude-cidr", "drop IPs within this CIDR; may be repeated")
+	var files multiFlag
+	flag.Var(&files, "file", "file to ingest; may be repeated")
+	flag.Parse()
+
 	// Clear screen for a cleaner view
 	fmt.Print("\033[H\033[2J")
 	fmt.Println("=== IP Address Processing Program ===")
 
-	// Validate arguments
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: go run main.go <file_path>")
+	cfg := sourceConfig{
+		files:     files,
+		listenTCP: *listenTCP,
+		listenUDP: *listenUDP,
+		stdin:     *stdin,
+	}
+	// Backward compatible positional file argument.
+	if flag.NArg() > 0 {
+		cfg.files = append(cfg.files, flag.Arg(0))
+	}
+	if cfg.empty() {
+		fmt.Println("Usage: go run . [flags] <file_path>")
+		fmt.Println("  or:  go run . --file=a.log --file=b.log --listen-tcp=:9000 --stdin")
 		return
 	}
-	filePath := os.Args[1]
-	fmt.Printf("\nProcessing file: %s\n", filePath)
 
 	// Initialize log file
 	logFile, err := os.Create(logFile)
@@ -59,6 +83,30 @@ func main() {
 	defer logFile.Close()
 	logWriter = bufio.NewWriter(logFile)
 
+	filter, err := newIPFilter(includeCIDRs, excludeCIDRs, *aggregateBy)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	// Each run* function wires up uniqueCounter for its backend before
+	// starting --http-stats, so the handler never reads a stale or nil
+	// counter for the active mode.
+	switch *mode {
+	case "hll":
+		runHLL(cfg, *exactVerify, filter, *httpStats)
+	case "exact":
+		runExact(cfg, *numShards, *numWriters, filter, *httpStats)
+	case "roaring":
+		runRoaring(cfg, filter, *httpStats)
+	default:
+		fmt.Printf("Unknown mode: %s (want exact, hll, or roaring)\n", *mode)
+	}
+}
+
+// runExact counts unique IPs by keying each into BadgerDB through a sharded
+// writer pool.
+func runExact(cfg sourceConfig, numShards, numWriters int, filter *ipFilter, httpStats string) {
 	// Initialize BadgerDB
 	opts := badger.DefaultOptions(dbPath).WithLogger(nil)
 	db, err := badger.Open(opts)
@@ -68,42 +116,67 @@ func main() {
 	}
 	defer db.Close()
 
-	// Channels and synchronization
 	linesChan := make(chan string, 10_000)
-	batchChan := make(chan []uint64, 100)
-	var wg sync.WaitGroup
+	var parseWG sync.WaitGroup
+	var writerWG sync.WaitGroup
 
-	// Start monitoring memory usage
+	// Start monitoring memory and writer throughput
 	go memoryMonitor()
 
-	// Start database writer
-	wg.Add(1)
-	go databaseWriter(batchChan, db, &wg)
+	// Start the sharded writer pool
+	pool := newWriterPool(db, numShards, numWriters, &writerWG)
+	go writerMetricsMonitor(&pool.metrics)
 
 	// Start worker goroutines
 	numWorkers := runtime.NumCPU()
-	fmt.Printf("\nUsing %d workers...\n", numWorkers)
+	fmt.Printf("\nUsing %d workers, %d shards, %d writers...\n", numWorkers, numShards, numWriters)
 	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go worker(linesChan, batchChan, &wg)
+		parseWG.Add(1)
+		go worker(linesChan, pool, filter, &parseWG)
 	}
 
-	// Read file
-	totalLines := readFile(filePath, linesChan)
+	// badgerCount walks every key, so cache it behind a short interval:
+	// otherwise a monitoring scraper hitting /stats repeatedly triggers a
+	// full-DB scan per request.
+	counter := newThrottledCounter(func() int64 { return badgerCount(db) }, 5*time.Second)
+	setUniqueCounter(counter.get)
+
+	if httpStats != "" {
+		go serveStats(httpStats)
+	}
 
-	// Wait for workers to finish
-	close(linesChan)
-	wg.Wait()
-	close(batchChan)
+	// Ingest from all configured sources
+	longRunning := startSources(cfg, linesChan)
+
+	// Wait for workers to finish (never, if a TCP/UDP listener is running)
+	parseWG.Wait()
+	pool.close()
+	writerWG.Wait()
 	logWriter.Flush()
 
+	if longRunning {
+		return
+	}
+
 	// Print final results
-	printResults(db, totalLines)
+	printResults(db)
+}
+
+func badgerCount(db *badger.DB) int64 {
+	var count int64
+	db.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			count++
+		}
+		return nil
+	})
+	return count
 }
 
 // Read file and send lines to channel
-func readFile(filePath string, linesChan chan<- string) int {
-	var totalLines int
+func readFile(filePath string, linesChan chan<- string) {
 	for attempt := 1; attempt <= retryAttempts; attempt++ {
 		file, err := os.Open(filePath)
 		if err != nil {
@@ -120,14 +193,11 @@ func readFile(filePath string, linesChan chan<- string) int {
 			if n > 0 {
 				for _, line := range splitLines(block[:n]) {
 					linesChan <- line
-					totalLines++
-					if totalLines%progressLog == 0 {
-						fmt.Printf("Processed %d lines...\n", totalLines)
-					}
+					countLine()
 				}
 			}
 			if err == io.EOF {
-				return totalLines
+				return
 			}
 			if err != nil {
 				fmt.Printf("Error reading file: %v\n", err)
@@ -135,44 +205,65 @@ func readFile(filePath string, linesChan chan<- string) int {
 			}
 		}
 	}
-	return totalLines
 }
 
-// Worker function
-func worker(linesChan <-chan string, batchChan chan<- []uint64, wg *sync.WaitGroup) {
+// Worker function. Each valid IP is keyed directly on its canonical byte
+// representation (4 bytes for IPv4, 16 for IPv6) rather than a hash, which
+// removes both the hashing cost and the (rare but real) risk of a hash
+// collision undercounting uniques. Keys are batched per shard so each batch
+// lands on a single writer goroutine in the pool.
+func worker(linesChan <-chan string, pool *writerPool, filter *ipFilter, wg *sync.WaitGroup) {
 	defer wg.Done()
-	var hashes []uint64
+	batches := make([][][]byte, pool.numShards)
 	for line := range linesChan {
 		ip := net.ParseIP(line)
-		if ip != nil {
-			hash := xxhash.Sum64([]byte(ip.String()))
-			hashes = append(hashes, hash)
-
-			// Send batch to channel
-			if len(hashes) >= batchSize {
-				batchChan <- hashes
-				hashes = nil
-			}
-		} else {
+		if ip == nil {
 			atomic.AddInt32(&invalidIPCount, 1)
 			if atomic.LoadInt32(&invalidLogCount) < maxInvalidLogs {
 				logWriter.WriteString(fmt.Sprintf("Warning: invalid IP address: %s\n", line))
 				atomic.AddInt32(&invalidLogCount, 1)
 			}
+			continue
+		}
+		if filter != nil {
+			var ok bool
+			ip, ok = filter.apply(ip)
+			if !ok {
+				continue
+			}
+		}
+
+		key := ipKey(ip)
+		shard := pool.shardIndex(key)
+		batches[shard] = append(batches[shard], key)
+		if len(batches[shard]) >= batchSize {
+			pool.shards[shard] <- batches[shard]
+			batches[shard] = nil
 		}
 	}
-	if len(hashes) > 0 {
-		batchChan <- hashes
+	for shard, batch := range batches {
+		if len(batch) > 0 {
+			pool.shards[shard] <- batch
+		}
 	}
 }
 
+// ipKey returns the canonical byte representation of ip: 4 bytes for IPv4,
+// 16 bytes for IPv6. net.ParseIP allocates a fresh backing array per call,
+// so the slice returned here is safe to retain without copying.
+func ipKey(ip net.IP) []byte {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip.To16()
+}
+
 // Database writer
-func databaseWriter(batchChan <-chan []uint64, db *badger.DB, wg *sync.WaitGroup) {
+func databaseWriter(batchChan <-chan [][]byte, db *badger.DB, wg *sync.WaitGroup) {
 	defer wg.Done()
 	for batch := range batchChan {
 		err := db.Update(func(txn *badger.Txn) error {
-			for _, hash := range batch {
-				key := itob(hash)
+			for _, key := range batch {
 				if err := txn.Set(key, []byte{}); err != nil {
 					return err
 				}
@@ -186,19 +277,9 @@ func databaseWriter(batchChan <-chan []uint64, db *badger.DB, wg *sync.WaitGroup
 }
 
 // Print final results
-func printResults(db *badger.DB, totalLines int) {
-	var uniqueCount int
-	db.View(func(txn *badger.Txn) error {
-		iter := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer iter.Close()
-		for iter.Rewind(); iter.Valid(); iter.Next() {
-			uniqueCount++
-		}
-		return nil
-	})
-
-	fmt.Printf("\nTotal lines processed: %d\n", totalLines)
-	fmt.Printf("Number of unique valid IP addresses: %d\n", uniqueCount)
+func printResults(db *badger.DB) {
+	fmt.Printf("\nTotal lines processed: %d\n", atomic.LoadInt64(&totalLineCount))
+	fmt.Printf("Number of unique valid IP addresses: %d\n", badgerCount(db))
 	fmt.Printf("Number of invalid IP addresses: %d\n", invalidIPCount)
 }
 
@@ -220,11 +301,6 @@ func splitLines(block []byte) []string {
 	return lines
 }
 
-// Convert int to byte slice
-func itob(v uint64) []byte {
-	return []byte(fmt.Sprintf("%d", v))
-}
-
 // Monitor memory usage
 func memoryMonitor() {
 	ticker := time.NewTicker(5 * time.Second)
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// syntheticIPs generates n sequential IPv4 addresses in 10.0.0.0/16, cycling
+// through that fixed pool of 65536 to mimic the repeated-address pattern of
+// a real log file.
+func syntheticIPs(n int) []net.IP {
+	ips := make([]net.IP, n)
+	for i := range ips {
+		a := byte(i % 256)
+		b := byte((i / 256) % 256)
+		ips[i] = net.IPv4(10, 0, a, b)
+	}
+	return ips
+}
+
+// BenchmarkKeyHashed reproduces the old path: hash the string form of the
+// IP with xxhash and encode it with fmt.Sprintf, as itob used to.
+func BenchmarkKeyHashed(b *testing.B) {
+	ips := syntheticIPs(100_000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ip := ips[i%len(ips)]
+		hash := xxhash.Sum64([]byte(ip.String()))
+		_ = []byte(fmt.Sprintf("%d", hash))
+	}
+}
+
+// BenchmarkKeyDirect is the current path: key directly on the canonical
+// byte representation, with no hashing and no allocation-heavy formatting.
+func BenchmarkKeyDirect(b *testing.B) {
+	ips := syntheticIPs(100_000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ip := ips[i%len(ips)]
+		_ = ipKey(ip)
+	}
+}
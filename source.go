@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// totalLineCount counts lines accepted across all sources (files, stdin,
+// TCP connections, UDP datagrams). Sources run concurrently, so this is
+// updated atomically rather than returned as a local total.
+var totalLineCount int64
+
+// multiFlag collects repeated occurrences of a flag (e.g. --file=a --file=b)
+// into a slice, for use with the flag package.
+type multiFlag []string
+
+func (m *multiFlag) String() string {
+	return fmt.Sprint([]string(*m))
+}
+
+func (m *multiFlag) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
+
+// sourceConfig describes the set of inputs to ingest concurrently.
+type sourceConfig struct {
+	files     []string
+	listenTCP string
+	listenUDP string
+	stdin     bool
+}
+
+func (c sourceConfig) empty() bool {
+	return len(c.files) == 0 && c.listenTCP == "" && c.listenUDP == "" && !c.stdin
+}
+
+// Source feeds lines, one IP per line, into linesChan. Stream blocks until
+// the source is exhausted (a file or stdin) or forever (a TCP or UDP
+// listener), so callers run it in its own goroutine.
+type Source interface {
+	Stream(linesChan chan<- string)
+}
+
+// fileSource streams a single file's contents.
+type fileSource struct{ path string }
+
+func (s fileSource) Stream(linesChan chan<- string) { readFile(s.path, linesChan) }
+
+// stdinSource streams standard input.
+type stdinSource struct{}
+
+func (stdinSource) Stream(linesChan chan<- string) { readStdin(linesChan) }
+
+// tcpSource accepts line-delimited IPs over TCP; it never returns on its own.
+type tcpSource struct{ addr string }
+
+func (s tcpSource) Stream(linesChan chan<- string) { serveTCP(s.addr, linesChan) }
+
+// udpSource reads one IP per UDP datagram; it never returns on its own.
+type udpSource struct{ addr string }
+
+func (s udpSource) Stream(linesChan chan<- string) { serveUDP(s.addr, linesChan) }
+
+// sourcesFromConfig builds the Sources described by cfg, split into finite
+// sources (files, stdin) that eventually drain and long-running sources
+// (TCP, UDP listeners) that don't.
+func sourcesFromConfig(cfg sourceConfig) (finite, longRunning []Source) {
+	for _, path := range cfg.files {
+		finite = append(finite, fileSource{path})
+	}
+	if cfg.stdin {
+		finite = append(finite, stdinSource{})
+	}
+	if cfg.listenTCP != "" {
+		longRunning = append(longRunning, tcpSource{cfg.listenTCP})
+	}
+	if cfg.listenUDP != "" {
+		longRunning = append(longRunning, udpSource{cfg.listenUDP})
+	}
+	return finite, longRunning
+}
+
+// startSources launches all configured sources feeding lines into linesChan.
+// Files and stdin are finite: once they've all been drained, linesChan is
+// closed automatically unless a TCP or UDP listener is also running, in
+// which case ingestion continues until the process is stopped; the
+// --http-stats server only reports totals and offers no shutdown.
+func startSources(cfg sourceConfig, linesChan chan<- string) (longRunning bool) {
+	finiteSources, longRunningSources := sourcesFromConfig(cfg)
+	longRunning = len(longRunningSources) > 0
+
+	var finite sync.WaitGroup
+	for _, src := range finiteSources {
+		finite.Add(1)
+		go func(src Source) {
+			defer finite.Done()
+			src.Stream(linesChan)
+		}(src)
+	}
+	for _, src := range longRunningSources {
+		go src.Stream(linesChan)
+	}
+
+	go func() {
+		finite.Wait()
+		if !longRunning {
+			close(linesChan)
+		}
+	}()
+
+	return longRunning
+}
+
+// readStdin streams lines from standard input, one IP per line.
+func readStdin(linesChan chan<- string) {
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, bufferSize), bufferSize)
+	for scanner.Scan() {
+		linesChan <- scanner.Text()
+		countLine()
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("Error reading stdin: %v\n", err)
+	}
+}
+
+// serveTCP accepts connections on addr and treats each line of each
+// connection as an IP address, feeding it into linesChan.
+func serveTCP(addr string, linesChan chan<- string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Printf("Error listening on %s (tcp): %v\n", addr, err)
+		return
+	}
+	defer listener.Close()
+	fmt.Printf("\nListening for TCP connections on %s...\n", addr)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Printf("Error accepting TCP connection: %v\n", err)
+			continue
+		}
+		go handleTCPConn(conn, linesChan)
+	}
+}
+
+func handleTCPConn(conn net.Conn, linesChan chan<- string) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, bufferSize), bufferSize)
+	for scanner.Scan() {
+		linesChan <- scanner.Text()
+		countLine()
+	}
+}
+
+// serveUDP reads one IP address per datagram from addr.
+func serveUDP(addr string, linesChan chan<- string) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		fmt.Printf("Error resolving %s (udp): %v\n", addr, err)
+		return
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		fmt.Printf("Error listening on %s (udp): %v\n", addr, err)
+		return
+	}
+	defer conn.Close()
+	fmt.Printf("\nListening for UDP datagrams on %s...\n", addr)
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			fmt.Printf("Error reading UDP datagram: %v\n", err)
+			continue
+		}
+		linesChan <- string(buf[:n])
+		countLine()
+	}
+}
+
+func countLine() {
+	n := atomic.AddInt64(&totalLineCount, 1)
+	if n%progressLog == 0 {
+		fmt.Printf("Processed %d lines...\n", n)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// uniqueCounter reports the current unique IP count for whichever backend
+// is active (exact BadgerDB scan or HLL/roaring estimate). It's stored in an
+// atomic.Value rather than a plain func var so setUniqueCounter (called
+// before ingestion starts) and the /stats handler (read concurrently from
+// any request goroutine) can't race.
+var uniqueCounter atomic.Value // func() int64
+
+// setUniqueCounter wires up the unique-count callback for the active
+// backend. Callers must invoke this before starting --http-stats, so the
+// handler never observes a nil value.
+func setUniqueCounter(fn func() int64) {
+	uniqueCounter.Store(fn)
+}
+
+// throttledCounter caches an expensive count behind an interval, so a
+// scraper hitting /stats repeatedly doesn't re-trigger a full scan (e.g.
+// badgerCount iterating every key) on every request.
+type throttledCounter struct {
+	fn       func() int64
+	interval time.Duration
+
+	mu       sync.Mutex
+	value    int64
+	lastScan time.Time
+}
+
+func newThrottledCounter(fn func() int64, interval time.Duration) *throttledCounter {
+	return &throttledCounter{fn: fn, interval: interval}
+}
+
+func (t *throttledCounter) get() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.lastScan) >= t.interval {
+		t.value = t.fn()
+		t.lastScan = time.Now()
+	}
+	return t.value
+}
+
+// statsResponse is the JSON body served by --http-stats.
+type statsResponse struct {
+	TotalLines   int64 `json:"total_lines"`
+	UniqueCount  int64 `json:"unique_count"`
+	InvalidCount int32 `json:"invalid_count"`
+	MemoryMB     int64 `json:"memory_mb"`
+}
+
+// serveStats exposes running totals as JSON for scraping by log shippers
+// or monitoring tools, e.g. `curl http://localhost:8080/stats`.
+func serveStats(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", handleStats)
+
+	fmt.Printf("\nServing stats on http://%s/stats\n", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		fmt.Printf("Error serving stats: %v\n", err)
+	}
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	var unique int64
+	if fn, ok := uniqueCounter.Load().(func() int64); ok {
+		unique = fn()
+	}
+
+	resp := statsResponse{
+		TotalLines:   atomic.LoadInt64(&totalLineCount),
+		UniqueCount:  unique,
+		InvalidCount: atomic.LoadInt32(&invalidIPCount),
+		MemoryMB:     int64(m.Alloc / 1024 / 1024),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}